@@ -0,0 +1,178 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec defines the wire encoding used for RPC envelopes (SyncRequest,
+// FastForwardResponse, and the hashgraph wire types they carry) once they
+// leave the connection-pooling logic in net_transport.go. It is negotiated
+// once per pooled connection, so a cluster can mix peers that prefer
+// different codecs without anyone falling over.
+type Codec interface {
+	// Name identifies the codec during negotiation; it must be stable
+	// across releases.
+	Name() string
+
+	// Encode writes v to w in this codec's format.
+	Encode(w io.Writer, v interface{}) error
+
+	// Decode reads a value of this codec's format from r into v.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// GobCodec is the original, Go-specific codec. It is always registered and
+// is the handshake's fallback, so a peer running an old build can always
+// be understood.
+type GobCodec struct{}
+
+// Name implements the Codec interface.
+func (GobCodec) Name() string { return "gob" }
+
+// Encode implements the Codec interface.
+func (GobCodec) Encode(w io.Writer, v interface{}) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+// Decode implements the Codec interface.
+func (GobCodec) Decode(r io.Reader, v interface{}) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+// msgpackHandle is shared across all MsgpackCodec encode/decode calls, as
+// recommended by ugorji/go/codec; it holds no per-call state.
+var msgpackHandle codec.MsgpackHandle
+
+// MsgpackCodec implements Codec using msgpack, a compact binary encoding
+// that isn't Go-specific, so non-Go clients can decode Sync/FastForward
+// traffic without speaking gob.
+type MsgpackCodec struct{}
+
+// Name implements the Codec interface.
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+// Encode implements the Codec interface.
+func (MsgpackCodec) Encode(w io.Writer, v interface{}) error {
+	return codec.NewEncoder(w, &msgpackHandle).Encode(v)
+}
+
+// Decode implements the Codec interface.
+func (MsgpackCodec) Decode(r io.Reader, v interface{}) error {
+	return codec.NewDecoder(r, &msgpackHandle).Decode(v)
+}
+
+// codecsByName is the registry of codecs available for negotiation.
+var codecsByName = map[string]Codec{
+	"gob":     GobCodec{},
+	"msgpack": MsgpackCodec{},
+}
+
+// defaultCodecPreference is offered during handshake, most preferred
+// first. The first name the server also supports wins.
+var defaultCodecPreference = []string{"msgpack", "gob"}
+
+// maxFrameSize bounds a single length-prefixed frame, to keep a corrupt or
+// malicious length header from triggering an unbounded allocation.
+const maxFrameSize = 512 * 1024 * 1024 // 512MB
+
+// writeFrame encodes v with c and writes it to w as a single
+// length-prefixed frame: a 4-byte big-endian length followed by that many
+// bytes of encoded payload. It returns the number of payload bytes
+// written, for metrics.
+func writeFrame(w io.Writer, c Codec, v interface{}) (int, error) {
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, v); err != nil {
+		return 0, err
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(buf.Len()))
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	_, err := w.Write(buf.Bytes())
+	return buf.Len(), err
+}
+
+// readFrame reads a single length-prefixed frame from r and decodes it
+// with c into v. It returns the number of payload bytes read, for
+// metrics.
+func readFrame(r io.Reader, c Codec, v interface{}) (int, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxFrameSize {
+		return 0, fmt.Errorf("babble-net: frame of %d bytes exceeds maximum of %d", n, maxFrameSize)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, err
+	}
+	return int(n), c.Decode(bytes.NewReader(payload), v)
+}
+
+// negotiateCodecClient runs the client half of the one-line codec
+// handshake performed once per freshly dialed connection: it offers its
+// codec preference list, and returns whichever codec the server chose.
+func negotiateCodecClient(r *bufio.Reader, w *bufio.Writer, preference []string) (Codec, error) {
+	if len(preference) == 0 {
+		preference = defaultCodecPreference
+	}
+
+	if _, err := w.WriteString(strings.Join(preference, ",") + "\n"); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(line)
+	c, ok := codecsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("babble-net: server chose unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// negotiateCodecServer runs the server half of the codec handshake: it
+// reads the client's preference list and replies with the first codec it
+// also supports, falling back to GobCodec if none match.
+func negotiateCodecServer(r *bufio.Reader, w *bufio.Writer) (Codec, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := Codec(GobCodec{})
+	for _, name := range strings.Split(strings.TrimSpace(line), ",") {
+		if c, ok := codecsByName[name]; ok {
+			chosen = c
+			break
+		}
+	}
+
+	if _, err := w.WriteString(chosen.Name() + "\n"); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return chosen, nil
+}
@@ -0,0 +1,75 @@
+package net
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/babbleio/babble/hashgraph"
+)
+
+// bigFastForwardResponse builds a FastForwardResponse carrying n events, to
+// approximate the payload a lagging node pulls during a real fast-forward.
+func bigFastForwardResponse(n int) *FastForwardResponse {
+	events := make([]hashgraph.Event, n)
+	for i := range events {
+		events[i] = hashgraph.Event{
+			Body: hashgraph.EventBody{
+				Transactions: [][]byte{[]byte("tx-payload")},
+				Parents:      []string{"p1", "p2"},
+				Creator:      []byte("creator"),
+				Index:        i,
+				Timestamp:    time.Now().UTC(),
+			},
+		}
+	}
+	return &FastForwardResponse{
+		From: "B",
+		Frame: hashgraph.Frame{
+			Roots: map[string]hashgraph.Root{
+				"0": hashgraph.Root{X: "x0", Y: "y0", Index: 4, Round: 2},
+			},
+			Events: events,
+		},
+	}
+}
+
+func benchmarkCodecEncode(b *testing.B, c Codec) {
+	resp := bigFastForwardResponse(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := c.Encode(&buf, resp); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func benchmarkCodecDecode(b *testing.B, c Codec) {
+	resp := bigFastForwardResponse(10000)
+	var encoded bytes.Buffer
+	if err := c.Encode(&encoded, resp); err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	b.SetBytes(int64(encoded.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out FastForwardResponse
+		if err := c.Decode(bytes.NewReader(encoded.Bytes()), &out); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
+
+// BenchmarkGobCodec_FastForwardResponse_10k measures gob's throughput
+// decoding a 10k-event FastForwardResponse, to compare against the newer
+// codecs below.
+func BenchmarkGobCodec_FastForwardResponse_10k(b *testing.B) {
+	benchmarkCodecDecode(b, GobCodec{})
+}
+
+// BenchmarkMsgpackCodec_FastForwardResponse_10k measures msgpack's
+// throughput on the same payload.
+func BenchmarkMsgpackCodec_FastForwardResponse_10k(b *testing.B) {
+	benchmarkCodecDecode(b, MsgpackCodec{})
+}
@@ -0,0 +1,135 @@
+package net
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babbleio/babble/common"
+	"github.com/babbleio/babble/hashgraph"
+)
+
+// TestNetworkTransport_Sync_Codecs runs the Sync RPC across every codec a
+// TCPTransport can negotiate, so each Codec implementation is held to the
+// same wire contract as the rest of the table-driven transport suite.
+func TestNetworkTransport_Sync_Codecs(t *testing.T) {
+	for name := range codecsByName {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			trans1, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+				BindAddr: "127.0.0.1:0",
+				MaxPool:  2,
+				Timeout:  time.Second,
+				Logger:   common.NewTestLogger(t),
+			})
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			defer trans1.Close()
+			rpcCh := trans1.Consumer()
+
+			trans2, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+				BindAddr: "127.0.0.1:0",
+				MaxPool:  2,
+				Timeout:  time.Second,
+				Logger:   common.NewTestLogger(t),
+				Codecs:   []string{name},
+			})
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			defer trans2.Close()
+
+			args := SyncRequest{
+				From: "A",
+				Known: map[int]int{
+					0: 1,
+					1: 2,
+				},
+			}
+			resp := SyncResponse{
+				From: "B",
+				Events: []hashgraph.WireEvent{
+					hashgraph.WireEvent{
+						Body: hashgraph.WireBody{
+							SelfParentIndex:      1,
+							OtherParentCreatorID: 10,
+							OtherParentIndex:     0,
+							CreatorID:            9,
+						},
+					},
+				},
+			}
+
+			go func() {
+				select {
+				case rpc := <-rpcCh:
+					req := rpc.Command.(*SyncRequest)
+					if !reflect.DeepEqual(req, &args) {
+						t.Fatalf("command mismatch: %#v %#v", *req, args)
+					}
+					rpc.Respond(&resp, nil)
+				case <-time.After(200 * time.Millisecond):
+					t.Fatalf("timeout")
+				}
+			}()
+
+			var out SyncResponse
+			if err := trans2.Sync(trans1.LocalAddr(), &args, &out); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !reflect.DeepEqual(resp, out) {
+				t.Fatalf("command mismatch: %#v %#v", resp, out)
+			}
+		})
+	}
+}
+
+// TestNetworkTransport_ErrorResponse_Codecs verifies that an error
+// returned by the RPC consumer survives the round trip for every codec.
+func TestNetworkTransport_ErrorResponse_Codecs(t *testing.T) {
+	for name := range codecsByName {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			trans1, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+				BindAddr: "127.0.0.1:0",
+				MaxPool:  2,
+				Timeout:  time.Second,
+				Logger:   common.NewTestLogger(t),
+			})
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			defer trans1.Close()
+			rpcCh := trans1.Consumer()
+
+			trans2, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+				BindAddr: "127.0.0.1:0",
+				MaxPool:  2,
+				Timeout:  time.Second,
+				Logger:   common.NewTestLogger(t),
+				Codecs:   []string{name},
+			})
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			defer trans2.Close()
+
+			go func() {
+				select {
+				case rpc := <-rpcCh:
+					rpc.Respond(nil, fmt.Errorf("boom"))
+				case <-time.After(200 * time.Millisecond):
+					t.Fatalf("timeout")
+				}
+			}()
+
+			var out SyncResponse
+			err = trans2.Sync(trans1.LocalAddr(), &SyncRequest{From: "A"}, &out)
+			if err == nil || err.Error() != "boom" {
+				t.Fatalf("expected error %q, got %v", "boom", err)
+			}
+		})
+	}
+}
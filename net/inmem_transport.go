@@ -0,0 +1,250 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LoopbackTransport is a Transport that can be bound to another instance of
+// itself in-process, without going through any real network stack. It is
+// used to build deterministic, socket-free test fixtures for the consensus
+// layer.
+type LoopbackTransport interface {
+	Transport
+
+	// Connect registers peer as the Transport reachable at addr, so that
+	// future calls to Sync/EagerSync/FastForward targeting addr are
+	// delivered straight to peer.
+	Connect(addr string, peer Transport)
+
+	// Disconnect removes any peer previously registered at addr.
+	Disconnect(addr string)
+
+	// DisconnectAll removes all previously registered peers.
+	DisconnectAll()
+}
+
+// InmemTransport implements LoopbackTransport for testing purposes. It
+// delivers every RPC through an in-process channel, so there is no
+// encoding, no socket, and no goroutine scheduling surprises beyond Go's
+// own scheduler.
+type InmemTransport struct {
+	sync.RWMutex
+
+	consumeCh chan RPC
+
+	localAddr string
+
+	peers map[string]*InmemTransport
+
+	timeout time.Duration
+
+	// pendingLatency, when non-zero, is applied to every RPC sent from
+	// this transport before it is delivered to the peer.
+	pendingLatency time.Duration
+
+	// dropped holds the set of RPC command type names (e.g. "*net.SyncRequest")
+	// that fail with an error instead of being delivered.
+	dropped map[string]bool
+
+	// partitioned holds the set of peer addresses this transport has been
+	// cut off from; RPCs this transport sends to them fail immediately.
+	// RPCs they send to this transport are not affected.
+	partitioned map[string]bool
+}
+
+// NewInmemTransport returns a new in-memory transport. If addr is empty, a
+// unique synthetic address is generated so the transport can still be used
+// as a registry key.
+func NewInmemTransport(addr string) (string, *InmemTransport) {
+	if addr == "" {
+		addr = NewInmemAddr()
+	}
+	trans := &InmemTransport{
+		consumeCh:   make(chan RPC, 16),
+		localAddr:   addr,
+		peers:       make(map[string]*InmemTransport),
+		dropped:     make(map[string]bool),
+		partitioned: make(map[string]bool),
+		timeout:     50 * time.Millisecond,
+	}
+	return addr, trans
+}
+
+var inmemAddrCounter uint64
+var inmemAddrLock sync.Mutex
+
+// NewInmemAddr returns a new synthetic address that is guaranteed to be
+// unique within the process.
+func NewInmemAddr() string {
+	inmemAddrLock.Lock()
+	defer inmemAddrLock.Unlock()
+	inmemAddrCounter++
+	return fmt.Sprintf("inmem.%d", inmemAddrCounter)
+}
+
+// Consumer implements the Transport interface.
+func (i *InmemTransport) Consumer() <-chan RPC {
+	return i.consumeCh
+}
+
+// LocalAddr implements the Transport interface.
+func (i *InmemTransport) LocalAddr() string {
+	return i.localAddr
+}
+
+// Sync implements the Transport interface.
+func (i *InmemTransport) Sync(target string, args *SyncRequest, resp *SyncResponse) error {
+	rpcResp, err := i.makeRPC(target, args)
+	if err != nil {
+		return err
+	}
+	out, ok := rpcResp.Response.(*SyncResponse)
+	if !ok {
+		return fmt.Errorf("unexpected response type %T", rpcResp.Response)
+	}
+	*resp = *out
+	return nil
+}
+
+// EagerSync implements the Transport interface.
+func (i *InmemTransport) EagerSync(target string, args *EagerSyncRequest, resp *EagerSyncResponse) error {
+	rpcResp, err := i.makeRPC(target, args)
+	if err != nil {
+		return err
+	}
+	out, ok := rpcResp.Response.(*EagerSyncResponse)
+	if !ok {
+		return fmt.Errorf("unexpected response type %T", rpcResp.Response)
+	}
+	*resp = *out
+	return nil
+}
+
+// FastForward implements the Transport interface.
+func (i *InmemTransport) FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error {
+	rpcResp, err := i.makeRPC(target, args)
+	if err != nil {
+		return err
+	}
+	out, ok := rpcResp.Response.(*FastForwardResponse)
+	if !ok {
+		return fmt.Errorf("unexpected response type %T", rpcResp.Response)
+	}
+	*resp = *out
+	return nil
+}
+
+// makeRPC looks up the peer registered at target and hands it the RPC,
+// honouring any injected latency, drop rules, or partitions.
+func (i *InmemTransport) makeRPC(target string, args interface{}) (RPCResponse, error) {
+	i.RLock()
+	peer, ok := i.peers[target]
+	latency := i.pendingLatency
+	dropped := i.dropped[fmt.Sprintf("%T", args)]
+	partitioned := i.partitioned[target]
+	i.RUnlock()
+
+	if !ok {
+		return RPCResponse{}, fmt.Errorf("failed to connect to peer: %v", target)
+	}
+	if partitioned {
+		return RPCResponse{}, fmt.Errorf("peer %v is partitioned", target)
+	}
+	if dropped {
+		return RPCResponse{}, fmt.Errorf("rpc %T dropped", args)
+	}
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
+		Command:  args,
+		RespChan: respCh,
+	}
+
+	timeout := time.After(i.timeout)
+	select {
+	case peer.consumeCh <- rpc:
+	case <-timeout:
+		return RPCResponse{}, fmt.Errorf("send timed out")
+	}
+
+	select {
+	case rpcResp := <-respCh:
+		if rpcResp.Error != nil {
+			return RPCResponse{}, rpcResp.Error
+		}
+		return rpcResp, nil
+	case <-timeout:
+		return RPCResponse{}, fmt.Errorf("command timed out")
+	}
+}
+
+// Close implements the Transport interface.
+func (i *InmemTransport) Close() error {
+	i.DisconnectAll()
+	return nil
+}
+
+// Connect implements the LoopbackTransport interface.
+func (i *InmemTransport) Connect(addr string, peer Transport) {
+	inmemPeer, ok := peer.(*InmemTransport)
+	if !ok {
+		panic("InmemTransport can only connect to another InmemTransport")
+	}
+	i.Lock()
+	defer i.Unlock()
+	i.peers[addr] = inmemPeer
+}
+
+// Disconnect implements the LoopbackTransport interface.
+func (i *InmemTransport) Disconnect(addr string) {
+	i.Lock()
+	defer i.Unlock()
+	delete(i.peers, addr)
+	delete(i.partitioned, addr)
+}
+
+// DisconnectAll implements the LoopbackTransport interface.
+func (i *InmemTransport) DisconnectAll() {
+	i.Lock()
+	defer i.Unlock()
+	i.peers = make(map[string]*InmemTransport)
+	i.partitioned = make(map[string]bool)
+}
+
+// SetLatency injects an artificial delay before every outbound RPC made by
+// this transport, to simulate a slow link.
+func (i *InmemTransport) SetLatency(latency time.Duration) {
+	i.Lock()
+	defer i.Unlock()
+	i.pendingLatency = latency
+}
+
+// DropRPC causes every subsequent outbound RPC of the given type (e.g.
+// &SyncRequest{}) to fail instead of being delivered, to simulate a
+// misbehaving or unreachable peer without tearing down the connection.
+func (i *InmemTransport) DropRPC(args interface{}) {
+	i.Lock()
+	defer i.Unlock()
+	i.dropped[fmt.Sprintf("%T", args)] = true
+}
+
+// Partition marks addr as unreachable, so future RPCs to it fail
+// immediately, simulating a network partition.
+func (i *InmemTransport) Partition(addr string) {
+	i.Lock()
+	defer i.Unlock()
+	i.partitioned[addr] = true
+}
+
+// Heal reverses a previous call to Partition.
+func (i *InmemTransport) Heal(addr string) {
+	i.Lock()
+	defer i.Unlock()
+	delete(i.partitioned, addr)
+}
@@ -0,0 +1,60 @@
+package net
+
+import (
+	gometrics "github.com/armon/go-metrics"
+)
+
+// Metrics records transport-level counters and histograms, so an operator
+// can see in-flight RPC volume, latency, error rates, and connection-pool
+// saturation before gossip degradation turns into a consensus stall. All
+// methods must be safe for concurrent use.
+type Metrics interface {
+	// IncrCounter increments the named counter by delta.
+	IncrCounter(key []string, delta float32)
+
+	// AddSample records a single observation (e.g. RPC latency in
+	// milliseconds, or frame size in bytes) into a histogram.
+	AddSample(key []string, val float32)
+}
+
+// NoopMetrics discards everything. It is the default when a transport is
+// built without an explicit Metrics sink.
+type NoopMetrics struct{}
+
+// IncrCounter implements the Metrics interface.
+func (NoopMetrics) IncrCounter(key []string, delta float32) {}
+
+// AddSample implements the Metrics interface.
+func (NoopMetrics) AddSample(key []string, val float32) {}
+
+// GoMetricsSink adapts Metrics to github.com/armon/go-metrics. It has no
+// state of its own: it reports to whatever sink the process registered
+// with gometrics.NewGlobal, which is how the rest of the Babble stack
+// configures metrics.
+type GoMetricsSink struct{}
+
+// IncrCounter implements the Metrics interface.
+func (GoMetricsSink) IncrCounter(key []string, delta float32) {
+	gometrics.IncrCounter(key, delta)
+}
+
+// AddSample implements the Metrics interface.
+func (GoMetricsSink) AddSample(key []string, val float32) {
+	gometrics.AddSample(key, val)
+}
+
+// rpcTypeName returns the metric label for an rpcType tag.
+func rpcTypeName(rpcType uint8) string {
+	switch rpcType {
+	case rpcSync:
+		return "Sync"
+	case rpcEagerSync:
+		return "EagerSync"
+	case rpcFastForward:
+		return "FastForward"
+	case rpcSyncStream:
+		return "SyncStream"
+	default:
+		return "unknown"
+	}
+}
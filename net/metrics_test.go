@@ -0,0 +1,165 @@
+package net
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/babbleio/babble/common"
+)
+
+// fakeMetrics is an in-memory Metrics used to assert on counter/sample
+// movement without depending on a real go-metrics sink.
+type fakeMetrics struct {
+	mu       sync.Mutex
+	counters map[string]float32
+	samples  map[string]int
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{
+		counters: make(map[string]float32),
+		samples:  make(map[string]int),
+	}
+}
+
+func (f *fakeMetrics) IncrCounter(key []string, delta float32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counters[strings.Join(key, ".")] += delta
+}
+
+func (f *fakeMetrics) AddSample(key []string, val float32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.samples[strings.Join(key, ".")]++
+}
+
+func (f *fakeMetrics) counter(key string) float32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.counters[key]
+}
+
+func (f *fakeMetrics) sampleCount(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.samples[key]
+}
+
+func TestNetworkTransport_Metrics_SyncCounters(t *testing.T) {
+	serverMetrics := newFakeMetrics()
+	trans1, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr: "127.0.0.1:0",
+		MaxPool:  2,
+		Timeout:  time.Second,
+		Logger:   common.NewTestLogger(t),
+		Metrics:  serverMetrics,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	clientMetrics := newFakeMetrics()
+	trans2, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr: "127.0.0.1:0",
+		MaxPool:  2,
+		Timeout:  time.Second,
+		Logger:   common.NewTestLogger(t),
+		Metrics:  clientMetrics,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	resp := SyncResponse{From: "B"}
+	go func() {
+		for i := 0; i < 3; i++ {
+			select {
+			case rpc := <-rpcCh:
+				rpc.Respond(&resp, nil)
+			case <-time.After(time.Second):
+				t.Errorf("timeout")
+				return
+			}
+		}
+	}()
+
+	addr := trans1.LocalAddr()
+	for i := 0; i < 3; i++ {
+		var out SyncResponse
+		if err := trans2.Sync(addr, &SyncRequest{From: "A"}, &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if got := clientMetrics.counter("babble.net.rpc.outbound.Sync"); got != 3 {
+		t.Fatalf("expected 3 outbound Sync RPCs, got %v", got)
+	}
+	if got := serverMetrics.counter("babble.net.rpc.inbound.Sync"); got != 3 {
+		t.Fatalf("expected 3 inbound Sync RPCs, got %v", got)
+	}
+	if clientMetrics.sampleCount("babble.net.rpc.latency.Sync") != 3 {
+		t.Fatalf("expected 3 latency samples")
+	}
+	if clientMetrics.sampleCount("babble.net.rpc.bytes.out.Sync") == 0 {
+		t.Fatalf("expected outbound byte samples")
+	}
+	if clientMetrics.sampleCount("babble.net.rpc.bytes.in.Sync") == 0 {
+		t.Fatalf("expected inbound byte samples")
+	}
+
+	// First call is a pool miss (dial), the following two reuse the
+	// pooled connection.
+	if got := clientMetrics.counter("babble.net.pool.miss"); got != 1 {
+		t.Fatalf("expected 1 pool miss, got %v", got)
+	}
+	if got := clientMetrics.counter("babble.net.pool.hit"); got != 2 {
+		t.Fatalf("expected 2 pool hits, got %v", got)
+	}
+}
+
+func TestNetworkTransport_Metrics_ErrorCounter(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	clientMetrics := newFakeMetrics()
+	trans2, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr: "127.0.0.1:0",
+		MaxPool:  2,
+		Timeout:  time.Second,
+		Logger:   common.NewTestLogger(t),
+		Metrics:  clientMetrics,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	go func() {
+		select {
+		case rpc := <-rpcCh:
+			rpc.Respond(nil, errors.New("boom"))
+		case <-time.After(time.Second):
+			t.Errorf("timeout")
+		}
+	}()
+
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &SyncRequest{From: "A"}, &out); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if got := clientMetrics.counter("babble.net.rpc.error.Sync"); got != 1 {
+		t.Fatalf("expected 1 Sync error, got %v", got)
+	}
+}
@@ -0,0 +1,656 @@
+package net
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	rpcSync uint8 = iota
+	rpcEagerSync
+	rpcFastForward
+	rpcSyncStream
+
+	// DefaultTimeoutScale is 256KB; TCPTransport does not read it.
+	DefaultTimeoutScale = 256 * 1024 // 256KB
+
+	// connReceiveBufferSize is the size of the buffer we use for reading
+	// RPC responses off the wire.
+	connReceiveBufferSize = 4096
+)
+
+// rpcStatusOK and rpcStatusError tag the response frame so the caller can
+// tell a successful response from one carrying an error message.
+const (
+	rpcStatusOK uint8 = iota
+	rpcStatusError
+)
+
+var (
+	errNotAdvertisable = errors.New("local bind address is not advertisable")
+	errNotTCP          = errors.New("local address is not a TCP address")
+)
+
+// StreamLayer is used with the TCPTransport to provide
+// the low level stream abstraction. It mirrors the usual dial/listen split
+// used elsewhere in the Go networking ecosystem, so operators can swap in
+// TLS, multiplexed, or other custom transports without touching the
+// connection-pooling logic above it.
+type StreamLayer interface {
+	net.Listener
+
+	// Dial is used to create a new outgoing connection
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// PeerAuthorizer decides whether a peer presenting cert is allowed to
+// participate in gossip. It is normally backed by the validator set that
+// the hashgraph layer already maintains, keyed off the certificate's CN or
+// SPKI. A nil PeerAuthorizer allows every peer whose certificate otherwise
+// satisfies the TLS config (e.g. is signed by a trusted CA).
+type PeerAuthorizer func(cert *x509.Certificate) bool
+
+// errUnauthorizedPeer is returned when a peer's certificate does not pass
+// the configured PeerAuthorizer.
+var errUnauthorizedPeer = errors.New("babble-net: peer certificate is not authorized")
+
+// TCPTransport provides a network-based transport that can be
+// used to communicate with Babble on remote machines. It requires
+// an underlying stream layer to provide a stream abstraction, which can be
+// simple TCP, TLS, etc.
+type TCPTransport struct {
+	connPool     map[string][]*netConn
+	connPoolLock sync.Mutex
+
+	consumeCh chan RPC
+
+	logger *log.Logger
+
+	maxPool int
+
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+
+	stream StreamLayer
+
+	timeout time.Duration
+
+	// codecPreference is offered, most preferred first, when negotiating
+	// the wire codec for a freshly dialed connection. Defaults to
+	// defaultCodecPreference.
+	codecPreference []string
+
+	metrics Metrics
+}
+
+// netConn wraps a net.Conn together with the Codec negotiated for it, so a
+// pooled connection can be reused without renegotiating on every call.
+type netConn struct {
+	target string
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	codec  Codec
+}
+
+func (n *netConn) Release() error {
+	return n.conn.Close()
+}
+
+// TCPTransportConfig configures a TCPTransport. BindAddr is the only
+// required field; everything else has a sane zero value.
+type TCPTransportConfig struct {
+	// BindAddr is the local address to listen on.
+	BindAddr string
+
+	// Advertise is the address advertised to peers, if different from
+	// BindAddr (e.g. behind NAT).
+	Advertise net.Addr
+
+	// MaxPool controls how many connections to a given peer are pooled for
+	// re-use.
+	MaxPool int
+
+	// Timeout applied to dials and RPC round-trips.
+	Timeout time.Duration
+
+	Logger *log.Logger
+
+	// TLSConfig, if set, upgrades both the listener and the dialer to
+	// mTLS: ClientAuth should be set to tls.RequireAndVerifyClientCert (or
+	// stronger) so that gossip between validators is authenticated and
+	// encrypted.
+	TLSConfig *tls.Config
+
+	// Authorize, if set, is consulted after a successful TLS handshake to
+	// decide whether the peer's certificate belongs to the authorized
+	// validator set. Ignored unless TLSConfig is also set.
+	Authorize PeerAuthorizer
+
+	// Stream, if set, is used as the StreamLayer directly instead of
+	// constructing one from the fields above, for callers that need a
+	// fully custom dial/listen implementation.
+	Stream StreamLayer
+
+	// Codecs is the codec preference list offered when negotiating the
+	// wire encoding for a freshly dialed connection, most preferred
+	// first. Defaults to defaultCodecPreference ("msgpack", "gob").
+	Codecs []string
+
+	// Metrics receives counters and histograms for inbound/outbound RPCs,
+	// pool hits/misses, handshake failures, and bytes in/out. Defaults to
+	// NoopMetrics.
+	Metrics Metrics
+}
+
+// NewTCPTransport creates a new network transport, binding to the given
+// address and advertising it (or the bind address, if advertise is nil) to
+// peers. maxPool controls how many connections to a given peer are pooled
+// for re-use.
+func NewTCPTransport(
+	bindAddr string,
+	advertise net.Addr,
+	maxPool int,
+	timeout time.Duration,
+	logger *log.Logger,
+) (*TCPTransport, error) {
+	return NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr:  bindAddr,
+		Advertise: advertise,
+		MaxPool:   maxPool,
+		Timeout:   timeout,
+		Logger:    logger,
+	})
+}
+
+// NewTCPTransportWithConfig creates a new network transport from a
+// TCPTransportConfig, allowing callers to enable mTLS or supply a fully
+// custom StreamLayer.
+func NewTCPTransportWithConfig(config *TCPTransportConfig) (*TCPTransport, error) {
+	stream := config.Stream
+	if stream == nil {
+		// Try to bind
+		tcpAddr, err := net.ResolveTCPAddr("tcp", config.BindAddr)
+		if err != nil {
+			return nil, err
+		}
+		list, err := net.ListenTCP("tcp", tcpAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		tcpStream := &TCPStreamLayer{
+			advertise: config.Advertise,
+			listener:  list,
+			tlsConfig: config.TLSConfig,
+			authorize: config.Authorize,
+		}
+
+		// Verify that we have a usable advertise address
+		addr, ok := tcpStream.Addr().(*net.TCPAddr)
+		if !ok {
+			list.Close()
+			return nil, errNotTCP
+		}
+		if addr.IP.IsUnspecified() {
+			list.Close()
+			return nil, errNotAdvertisable
+		}
+		stream = tcpStream
+	}
+
+	trans := NewNetworkTransport(stream, config.MaxPool, config.Timeout, config.Logger)
+	if len(config.Codecs) > 0 {
+		trans.codecPreference = config.Codecs
+	}
+	if config.Metrics != nil {
+		trans.metrics = config.Metrics
+	}
+	return trans, nil
+}
+
+// NewNetworkTransport creates a new network transport with the given stream
+// layer, pool size, timeout and logger.
+func NewNetworkTransport(
+	stream StreamLayer,
+	maxPool int,
+	timeout time.Duration,
+	logger *log.Logger,
+) *TCPTransport {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	trans := &TCPTransport{
+		connPool:        make(map[string][]*netConn),
+		consumeCh:       make(chan RPC),
+		logger:          logger,
+		maxPool:         maxPool,
+		shutdownCh:      make(chan struct{}),
+		stream:          stream,
+		timeout:         timeout,
+		codecPreference: defaultCodecPreference,
+		metrics:         NoopMetrics{},
+	}
+	go trans.listen()
+	return trans
+}
+
+// Consumer implements the Transport interface.
+func (t *TCPTransport) Consumer() <-chan RPC {
+	return t.consumeCh
+}
+
+// LocalAddr implements the Transport interface.
+func (t *TCPTransport) LocalAddr() string {
+	return t.stream.Addr().String()
+}
+
+// IsShutdown is used to check if the transport is shutdown.
+func (t *TCPTransport) IsShutdown() bool {
+	select {
+	case <-t.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close implements the Transport interface.
+func (t *TCPTransport) Close() error {
+	t.shutdownLock.Lock()
+	defer t.shutdownLock.Unlock()
+
+	if !t.shutdown {
+		close(t.shutdownCh)
+		t.stream.Close()
+		t.shutdown = true
+	}
+	return nil
+}
+
+// getPooledConn returns a pooled connection for the target, if one is
+// available.
+func (t *TCPTransport) getPooledConn(target string) *netConn {
+	t.connPoolLock.Lock()
+	defer t.connPoolLock.Unlock()
+
+	conns, ok := t.connPool[target]
+	if !ok || len(conns) == 0 {
+		return nil
+	}
+
+	var conn *netConn
+	num := len(conns)
+	conn, conns[num-1] = conns[num-1], nil
+	t.connPool[target] = conns[:num-1]
+	return conn
+}
+
+// getConn is used to get a connection from the pool, or to create a new one
+// if none is available.
+func (t *TCPTransport) getConn(target string) (*netConn, error) {
+	// Check for a pooled conn
+	if conn := t.getPooledConn(target); conn != nil {
+		t.metrics.IncrCounter([]string{"babble", "net", "pool", "hit"}, 1)
+		return conn, nil
+	}
+	t.metrics.IncrCounter([]string{"babble", "net", "pool", "miss"}, 1)
+
+	// Dial a new connection
+	conn, err := t.stream.Dial(target, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	nc := &netConn{
+		target: target,
+		conn:   conn,
+		r:      bufio.NewReaderSize(conn, connReceiveBufferSize),
+		w:      bufio.NewWriter(conn),
+	}
+
+	codec, err := negotiateCodecClient(nc.r, nc.w, t.codecPreference)
+	if err != nil {
+		nc.Release()
+		t.metrics.IncrCounter([]string{"babble", "net", "handshake", "failure"}, 1)
+		return nil, err
+	}
+	nc.codec = codec
+
+	return nc, nil
+}
+
+// returnConn returns a connection back to the pool for re-use. It clears
+// any deadline genericRPC may have set, so a conn that's pinned for a
+// SyncStream (or reused for another RPC) doesn't inherit a stale,
+// already-elapsed deadline from its previous use.
+func (t *TCPTransport) returnConn(conn *netConn) {
+	conn.conn.SetDeadline(time.Time{})
+
+	t.connPoolLock.Lock()
+	defer t.connPoolLock.Unlock()
+
+	key := conn.target
+	conns, _ := t.connPool[key]
+
+	if !t.IsShutdown() && len(conns) < t.maxPool {
+		t.connPool[key] = append(conns, conn)
+	} else {
+		conn.Release()
+	}
+}
+
+// Sync implements the Transport interface.
+func (t *TCPTransport) Sync(target string, args *SyncRequest, resp *SyncResponse) error {
+	return t.genericRPC(target, rpcSync, args, resp)
+}
+
+// EagerSync implements the Transport interface.
+func (t *TCPTransport) EagerSync(target string, args *EagerSyncRequest, resp *EagerSyncResponse) error {
+	return t.genericRPC(target, rpcEagerSync, args, resp)
+}
+
+// FastForward implements the Transport interface.
+func (t *TCPTransport) FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error {
+	return t.genericRPC(target, rpcFastForward, args, resp)
+}
+
+// genericRPC dials (or reuses a pooled connection to) the target, writes the
+// rpcType tag and args, and decodes the response into resp.
+func (t *TCPTransport) genericRPC(target string, rpcType uint8, args interface{}, resp interface{}) error {
+	name := rpcTypeName(rpcType)
+	start := time.Now()
+	t.metrics.IncrCounter([]string{"babble", "net", "rpc", "outbound", name}, 1)
+	defer func() {
+		t.metrics.AddSample([]string{"babble", "net", "rpc", "latency", name}, float32(time.Since(start).Milliseconds()))
+	}()
+
+	// Get a conn
+	conn, err := t.getConn(target)
+	if err != nil {
+		t.metrics.IncrCounter([]string{"babble", "net", "rpc", "error", name}, 1)
+		return err
+	}
+
+	// Set a deadline
+	if t.timeout > 0 {
+		conn.conn.SetDeadline(time.Now().Add(t.timeout))
+	}
+
+	// Send the RPC
+	sent, err := sendRPC(conn, rpcType, args)
+	t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "out", name}, float32(sent))
+	if err != nil {
+		t.metrics.IncrCounter([]string{"babble", "net", "rpc", "error", name}, 1)
+		return err
+	}
+
+	// Decode the response
+	received, canReturn, err := decodeResponse(conn, resp)
+	t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "in", name}, float32(received))
+	if canReturn {
+		t.returnConn(conn)
+	}
+	if err != nil {
+		t.metrics.IncrCounter([]string{"babble", "net", "rpc", "error", name}, 1)
+	}
+	return err
+}
+
+// sendRPC encodes and sends the RPC over a connection, returning the
+// number of payload bytes written, for metrics.
+func sendRPC(conn *netConn, rpcType uint8, args interface{}) (int, error) {
+	if err := conn.w.WriteByte(rpcType); err != nil {
+		conn.Release()
+		return 0, err
+	}
+	n, err := writeFrame(conn.w, conn.codec, args)
+	if err != nil {
+		conn.Release()
+		return n, err
+	}
+	if err := conn.w.Flush(); err != nil {
+		conn.Release()
+		return n, err
+	}
+	return n, nil
+}
+
+// decodeResponse decodes an RPC response, reporting the number of payload
+// bytes read and whether the connection can be reused afterward.
+func decodeResponse(conn *netConn, resp interface{}) (int, bool, error) {
+	status, err := conn.r.ReadByte()
+	if err != nil {
+		conn.Release()
+		return 0, false, err
+	}
+
+	if status == rpcStatusError {
+		var msg string
+		n, err := readFrame(conn.r, conn.codec, &msg)
+		if err != nil {
+			conn.Release()
+			return n, false, err
+		}
+		return n, true, errors.New(msg)
+	}
+
+	n, err := readFrame(conn.r, conn.codec, resp)
+	if err != nil {
+		conn.Release()
+		return n, false, err
+	}
+	return n, true, nil
+}
+
+// listen is run in a goroutine to accept incoming connections.
+func (t *TCPTransport) listen() {
+	for {
+		conn, err := t.stream.Accept()
+		if err != nil {
+			if t.IsShutdown() {
+				return
+			}
+			t.logger.Printf("[ERR] babble-net: Failed to accept connection: %v", err)
+			continue
+		}
+
+		go t.handleConn(conn)
+	}
+}
+
+// handleConn is used to handle an inbound connection for its entire
+// lifetime, servicing every RPC sent over it until it is closed. For a TLS
+// listener, conn is an unhandshaked *tls.Conn: the handshake and peer
+// authorization happen here, off the accept loop, so one slow or
+// malicious peer can only block its own goroutine.
+func (t *TCPTransport) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			t.metrics.IncrCounter([]string{"babble", "net", "handshake", "failure"}, 1)
+			t.logger.Printf("[ERR] babble-net: TLS handshake failed: %v", err)
+			return
+		}
+		if streamLayer, ok := t.stream.(*TCPStreamLayer); ok {
+			if err := streamLayer.checkAuthorized(tlsConn); err != nil {
+				t.metrics.IncrCounter([]string{"babble", "net", "handshake", "failure"}, 1)
+				t.logger.Printf("[ERR] babble-net: %v", err)
+				return
+			}
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	codec, err := negotiateCodecServer(r, w)
+	if err != nil {
+		t.metrics.IncrCounter([]string{"babble", "net", "handshake", "failure"}, 1)
+		t.logger.Printf("[ERR] babble-net: Failed to negotiate codec: %v", err)
+		return
+	}
+
+	for {
+		if err := t.handleCommand(r, w, codec); err != nil {
+			if err != io.EOF {
+				t.logger.Printf("[ERR] babble-net: Failed to decode incoming command: %v", err)
+			}
+			return
+		}
+		if err := w.Flush(); err != nil {
+			t.logger.Printf("[ERR] babble-net: Failed to flush response: %v", err)
+			return
+		}
+	}
+}
+
+// handleCommand decodes a single RPC off of r using codec, dispatches it
+// to the consumer, and writes the response to w with the same codec.
+func (t *TCPTransport) handleCommand(r *bufio.Reader, w *bufio.Writer, c Codec) error {
+	rpcType, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if rpcType == rpcSyncStream {
+		return t.handleSyncStream(r, w, c)
+	}
+
+	name := rpcTypeName(rpcType)
+	t.metrics.IncrCounter([]string{"babble", "net", "rpc", "inbound", name}, 1)
+
+	var command interface{}
+	switch rpcType {
+	case rpcSync:
+		command = new(SyncRequest)
+	case rpcEagerSync:
+		command = new(EagerSyncRequest)
+	case rpcFastForward:
+		command = new(FastForwardRequest)
+	default:
+		return fmt.Errorf("unknown rpc type %d", rpcType)
+	}
+
+	received, err := readFrame(r, c, command)
+	if err != nil {
+		return err
+	}
+	t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "in", name}, float32(received))
+
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
+		Command:  command,
+		RespChan: respCh,
+	}
+
+	select {
+	case t.consumeCh <- rpc:
+	case <-t.shutdownCh:
+		return fmt.Errorf("transport shutdown")
+	}
+
+	select {
+	case resp := <-respCh:
+		if resp.Error != nil {
+			t.metrics.IncrCounter([]string{"babble", "net", "rpc", "error", name}, 1)
+			if err := w.WriteByte(rpcStatusError); err != nil {
+				return err
+			}
+			sent, err := writeFrame(w, c, resp.Error.Error())
+			t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "out", name}, float32(sent))
+			return err
+		}
+		if err := w.WriteByte(rpcStatusOK); err != nil {
+			return err
+		}
+		sent, err := writeFrame(w, c, resp.Response)
+		t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "out", name}, float32(sent))
+		return err
+	case <-t.shutdownCh:
+		return fmt.Errorf("transport shutdown")
+	}
+}
+
+// TCPStreamLayer implements StreamLayer with plain TCP, optionally upgraded
+// to mTLS when tlsConfig is set.
+type TCPStreamLayer struct {
+	advertise net.Addr
+	listener  *net.TCPListener
+	tlsConfig *tls.Config
+	authorize PeerAuthorizer
+}
+
+// Dial implements the StreamLayer interface.
+func (t *TCPStreamLayer) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	if t.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: timeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", address, t.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.checkAuthorized(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// Accept implements the net.Listener interface. When tlsConfig is set, it
+// wraps the accepted conn in a *tls.Conn but does not perform the
+// handshake: that (and peer authorization) happens lazily in the
+// per-connection goroutine, so a peer that completes the TCP accept but
+// stalls its TLS handshake can't block the single-threaded accept loop
+// from servicing every other peer.
+func (t *TCPStreamLayer) Accept() (c net.Conn, err error) {
+	conn, err := t.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.tlsConfig != nil {
+		return tls.Server(conn, t.tlsConfig), nil
+	}
+
+	return conn, nil
+}
+
+// checkAuthorized runs the configured PeerAuthorizer, if any, against the
+// peer certificate presented on conn.
+func (t *TCPStreamLayer) checkAuthorized(conn *tls.Conn) error {
+	if t.authorize == nil {
+		return nil
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 || !t.authorize(certs[0]) {
+		return errUnauthorizedPeer
+	}
+	return nil
+}
+
+// Close implements the net.Listener interface.
+func (t *TCPStreamLayer) Close() (err error) {
+	return t.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (t *TCPStreamLayer) Addr() net.Addr {
+	// Use an advertise addr if provided
+	if t.advertise != nil {
+		return t.advertise
+	}
+	return t.listener.Addr()
+}
@@ -1,6 +1,7 @@
 package net
 
 import (
+	"fmt"
 	"reflect"
 	"sync"
 	"testing"
@@ -10,235 +11,296 @@ import (
 	"github.com/babbleio/babble/hashgraph"
 )
 
-func TestNetworkTransport_StartStop(t *testing.T) {
-	trans, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
+// transportKind enumerates the Transport implementations that the tests in
+// this file are run against, so that every implementation is held to the
+// same contract.
+type transportKind int
+
+const (
+	TT_INMEM transportKind = iota
+	TT_TCP
+)
+
+func (k transportKind) String() string {
+	switch k {
+	case TT_INMEM:
+		return "InmemTransport"
+	case TT_TCP:
+		return "TCPTransport"
+	default:
+		return "unknown"
 	}
-	trans.Close()
 }
 
-func TestNetworkTransport_Sync(t *testing.T) {
-	// Transport 1 is consumer
-	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
+// transportKinds is the set of transports every table-driven test below is
+// run against.
+var transportKinds = []transportKind{TT_INMEM, TT_TCP}
+
+// NewTestTransport builds a Transport of the given kind, bound to addr (if
+// addr is non-empty and the kind supports choosing its own address), and
+// returns the address it can be reached at along with the Transport itself.
+func NewTestTransport(t testing.TB, kind transportKind, addr string) (string, Transport) {
+	switch kind {
+	case TT_INMEM:
+		return NewInmemTransport(addr)
+	case TT_TCP:
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		trans, err := NewTCPTransport(addr, nil, 2, time.Second, common.NewTestLogger(t))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return trans.LocalAddr(), trans
+	default:
+		t.Fatalf("unknown transport kind: %v", kind)
+		return "", nil
 	}
-	defer trans1.Close()
-	rpcCh := trans1.Consumer()
+}
 
-	// Make the RPC request
-	args := SyncRequest{
-		From: "A",
-		Known: map[int]int{
-			0: 1,
-			1: 2,
-			2: 3,
-		},
+// connectTransports wires trans1 and trans2 together so that each can reach
+// the other by address. For the TCP transport this is a no-op, since
+// connectivity is implicit in the network; for the in-memory transport the
+// peers must be explicitly registered with each other.
+func connectTransports(addr1 string, trans1 Transport, addr2 string, trans2 Transport) {
+	lb1, ok1 := trans1.(LoopbackTransport)
+	lb2, ok2 := trans2.(LoopbackTransport)
+	if ok1 && ok2 {
+		lb1.Connect(addr2, trans2)
+		lb2.Connect(addr1, trans1)
 	}
-	resp := SyncResponse{
-		From: "B",
-		Events: []hashgraph.WireEvent{
-			hashgraph.WireEvent{
-				Body: hashgraph.WireBody{
-					Transactions:         [][]byte(nil),
-					SelfParentIndex:      1,
-					OtherParentCreatorID: 10,
-					OtherParentIndex:     0,
-					CreatorID:            9,
-				},
-			},
-		},
-		Known: map[int]int{
-			0: 5,
-			1: 5,
-			2: 6,
-		},
+}
+
+func TestNetworkTransport_StartStop(t *testing.T) {
+	for _, kind := range transportKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			_, trans := NewTestTransport(t, kind, "127.0.0.1:0")
+			trans.Close()
+		})
 	}
+}
 
-	// Listen for a request
-	go func() {
-		select {
-		case rpc := <-rpcCh:
-			// Verify the command
-			req := rpc.Command.(*SyncRequest)
-			if !reflect.DeepEqual(req, &args) {
-				t.Fatalf("command mismatch: %#v %#v", *req, args)
+func TestNetworkTransport_Sync(t *testing.T) {
+	for _, kind := range transportKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			// Transport 1 is consumer
+			addr1, trans1 := NewTestTransport(t, kind, "127.0.0.1:0")
+			defer trans1.Close()
+			rpcCh := trans1.Consumer()
+
+			// Transport 2 makes outbound request
+			addr2, trans2 := NewTestTransport(t, kind, "127.0.0.1:0")
+			defer trans2.Close()
+
+			connectTransports(addr1, trans1, addr2, trans2)
+
+			// Make the RPC request
+			args := SyncRequest{
+				From: "A",
+				Known: map[int]int{
+					0: 1,
+					1: 2,
+					2: 3,
+				},
+			}
+			resp := SyncResponse{
+				From: "B",
+				Events: []hashgraph.WireEvent{
+					hashgraph.WireEvent{
+						Body: hashgraph.WireBody{
+							Transactions:         [][]byte(nil),
+							SelfParentIndex:      1,
+							OtherParentCreatorID: 10,
+							OtherParentIndex:     0,
+							CreatorID:            9,
+						},
+					},
+				},
+				Known: map[int]int{
+					0: 5,
+					1: 5,
+					2: 6,
+				},
 			}
 
-			rpc.Respond(&resp, nil)
+			// Listen for a request
+			go func() {
+				select {
+				case rpc := <-rpcCh:
+					// Verify the command
+					req := rpc.Command.(*SyncRequest)
+					if !reflect.DeepEqual(req, &args) {
+						t.Fatalf("command mismatch: %#v %#v", *req, args)
+					}
 
-		case <-time.After(200 * time.Millisecond):
-			t.Fatalf("timeout")
-		}
-	}()
+					rpc.Respond(&resp, nil)
 
-	// Transport 2 makes outbound request
-	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	defer trans2.Close()
+				case <-time.After(200 * time.Millisecond):
+					t.Fatalf("timeout")
+				}
+			}()
 
-	var out SyncResponse
-	if err := trans2.Sync(trans1.LocalAddr(), &args, &out); err != nil {
-		t.Fatalf("err: %v", err)
-	}
+			var out SyncResponse
+			if err := trans2.Sync(addr1, &args, &out); err != nil {
+				t.Fatalf("err: %v", err)
+			}
 
-	// Verify the response
-	if !reflect.DeepEqual(resp, out) {
-		t.Fatalf("command mismatch: %#v %#v", resp, out)
+			// Verify the response
+			if !reflect.DeepEqual(resp, out) {
+				t.Fatalf("command mismatch: %#v %#v", resp, out)
+			}
+		})
 	}
 }
 
 func TestNetworkTransport_EagerSync(t *testing.T) {
-	// Transport 1 is consumer
-	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	defer trans1.Close()
-	rpcCh := trans1.Consumer()
-
-	// Make the RPC request
-	args := EagerSyncRequest{
-		From: "A",
-		Events: []hashgraph.WireEvent{
-			hashgraph.WireEvent{
-				Body: hashgraph.WireBody{
-					Transactions:         [][]byte(nil),
-					SelfParentIndex:      1,
-					OtherParentCreatorID: 10,
-					OtherParentIndex:     0,
-					CreatorID:            9,
+	for _, kind := range transportKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			// Transport 1 is consumer
+			addr1, trans1 := NewTestTransport(t, kind, "127.0.0.1:0")
+			defer trans1.Close()
+			rpcCh := trans1.Consumer()
+
+			// Transport 2 makes outbound request
+			addr2, trans2 := NewTestTransport(t, kind, "127.0.0.1:0")
+			defer trans2.Close()
+
+			connectTransports(addr1, trans1, addr2, trans2)
+
+			// Make the RPC request
+			args := EagerSyncRequest{
+				From: "A",
+				Events: []hashgraph.WireEvent{
+					hashgraph.WireEvent{
+						Body: hashgraph.WireBody{
+							Transactions:         [][]byte(nil),
+							SelfParentIndex:      1,
+							OtherParentCreatorID: 10,
+							OtherParentIndex:     0,
+							CreatorID:            9,
+						},
+					},
 				},
-			},
-		},
-	}
-	resp := EagerSyncResponse{
-		Success: true,
-	}
-
-	// Listen for a request
-	go func() {
-		select {
-		case rpc := <-rpcCh:
-			// Verify the command
-			req := rpc.Command.(*EagerSyncRequest)
-			if !reflect.DeepEqual(req, &args) {
-				t.Fatalf("command mismatch: %#v %#v", *req, args)
+			}
+			resp := EagerSyncResponse{
+				Success: true,
 			}
 
-			rpc.Respond(&resp, nil)
+			// Listen for a request
+			go func() {
+				select {
+				case rpc := <-rpcCh:
+					// Verify the command
+					req := rpc.Command.(*EagerSyncRequest)
+					if !reflect.DeepEqual(req, &args) {
+						t.Fatalf("command mismatch: %#v %#v", *req, args)
+					}
 
-		case <-time.After(200 * time.Millisecond):
-			t.Fatalf("timeout")
-		}
-	}()
+					rpc.Respond(&resp, nil)
 
-	// Transport 2 makes outbound request
-	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	defer trans2.Close()
+				case <-time.After(200 * time.Millisecond):
+					t.Fatalf("timeout")
+				}
+			}()
 
-	var out EagerSyncResponse
-	if err := trans2.EagerSync(trans1.LocalAddr(), &args, &out); err != nil {
-		t.Fatalf("err: %v", err)
-	}
+			var out EagerSyncResponse
+			if err := trans2.EagerSync(addr1, &args, &out); err != nil {
+				t.Fatalf("err: %v", err)
+			}
 
-	// Verify the response
-	if !reflect.DeepEqual(resp, out) {
-		t.Fatalf("command mismatch: %#v %#v", resp, out)
+			// Verify the response
+			if !reflect.DeepEqual(resp, out) {
+				t.Fatalf("command mismatch: %#v %#v", resp, out)
+			}
+		})
 	}
 }
 
 func TestNetworkTransport_FastForward(t *testing.T) {
-	// Transport 1 is consumer
-	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	defer trans1.Close()
-	rpcCh := trans1.Consumer()
-
-	// Make the RPC request
-	args := FastForwardRequest{
-		From: "A",
-	}
-	resp := FastForwardResponse{
-		From: "B",
-		Frame: hashgraph.Frame{
-			Roots: map[string]hashgraph.Root{
-				"0": hashgraph.Root{
-					X:     "x0",
-					Y:     "y0",
-					Index: 4,
-					Round: 2,
-					Others: map[string]string{
-						"o1": "oldEvent",
+	for _, kind := range transportKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			// Transport 1 is consumer
+			addr1, trans1 := NewTestTransport(t, kind, "127.0.0.1:0")
+			defer trans1.Close()
+			rpcCh := trans1.Consumer()
+
+			// Transport 2 makes outbound request
+			addr2, trans2 := NewTestTransport(t, kind, "127.0.0.1:0")
+			defer trans2.Close()
+
+			connectTransports(addr1, trans1, addr2, trans2)
+
+			// Make the RPC request
+			args := FastForwardRequest{
+				From: "A",
+			}
+			resp := FastForwardResponse{
+				From: "B",
+				Frame: hashgraph.Frame{
+					Roots: map[string]hashgraph.Root{
+						"0": hashgraph.Root{
+							X:     "x0",
+							Y:     "y0",
+							Index: 4,
+							Round: 2,
+							Others: map[string]string{
+								"o1": "oldEvent",
+							},
+						},
+						"1": hashgraph.Root{
+							X:     "x1",
+							Y:     "y1",
+							Index: 4,
+							Round: 2,
+						},
+						"2": hashgraph.Root{
+							X:     "x2",
+							Y:     "y2",
+							Index: 4,
+							Round: 2,
+						},
 					},
-				},
-				"1": hashgraph.Root{
-					X:     "x1",
-					Y:     "y1",
-					Index: 4,
-					Round: 2,
-				},
-				"2": hashgraph.Root{
-					X:     "x2",
-					Y:     "y2",
-					Index: 4,
-					Round: 2,
-				},
-			},
-			Events: []hashgraph.Event{
-				hashgraph.Event{
-					Body: hashgraph.EventBody{
-						Transactions: [][]byte(nil),
-						Parents:      []string{"p1", "p2"},
-						Creator:      []byte("creator"),
-						Index:        19,
-						Timestamp:    time.Now().UTC(),
+					Events: []hashgraph.Event{
+						hashgraph.Event{
+							Body: hashgraph.EventBody{
+								Transactions: [][]byte(nil),
+								Parents:      []string{"p1", "p2"},
+								Creator:      []byte("creator"),
+								Index:        19,
+								Timestamp:    time.Now().UTC(),
+							},
+						},
 					},
 				},
-			},
-		},
-	}
-
-	// Listen for a request
-	go func() {
-		select {
-		case rpc := <-rpcCh:
-			// Verify the command
-			req := rpc.Command.(*FastForwardRequest)
-			if !reflect.DeepEqual(req, &args) {
-				t.Fatalf("command mismatch: %#v %#v", *req, args)
 			}
 
-			rpc.Respond(&resp, nil)
+			// Listen for a request
+			go func() {
+				select {
+				case rpc := <-rpcCh:
+					// Verify the command
+					req := rpc.Command.(*FastForwardRequest)
+					if !reflect.DeepEqual(req, &args) {
+						t.Fatalf("command mismatch: %#v %#v", *req, args)
+					}
 
-		case <-time.After(200 * time.Millisecond):
-			t.Fatalf("timeout")
-		}
-	}()
+					rpc.Respond(&resp, nil)
 
-	// Transport 2 makes outbound request
-	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
-	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-	defer trans2.Close()
+				case <-time.After(200 * time.Millisecond):
+					t.Fatalf("timeout")
+				}
+			}()
 
-	var out FastForwardResponse
-	if err := trans2.FastForward(trans1.LocalAddr(), &args, &out); err != nil {
-		t.Fatalf("err: %v", err)
-	}
+			var out FastForwardResponse
+			if err := trans2.FastForward(addr1, &args, &out); err != nil {
+				t.Fatalf("err: %v", err)
+			}
 
-	// Verify the response
-	if !reflect.DeepEqual(resp, out) {
-		t.Fatalf("command mismatch: %#v %#v", resp, out)
+			// Verify the response
+			if !reflect.DeepEqual(resp, out) {
+				t.Fatalf("command mismatch: %#v %#v", resp, out)
+			}
+		})
 	}
 }
 
@@ -331,3 +393,84 @@ func TestNetworkTransport_PooledConn(t *testing.T) {
 		t.Fatalf("Expected 2 pooled conns!")
 	}
 }
+
+// TestInmemTransport_Partition verifies that InmemTransport's fault
+// injection hooks (used by higher-level packages to build deterministic
+// consensus scenarios) behave as advertised.
+func TestInmemTransport_Partition(t *testing.T) {
+	addr1, trans1 := NewInmemTransport("")
+	defer trans1.Close()
+
+	addr2, trans2 := NewInmemTransport("")
+	defer trans2.Close()
+
+	connectTransports(addr1, trans1, addr2, trans2)
+
+	args := SyncRequest{From: "A"}
+	resp := SyncResponse{From: "B"}
+
+	rpcCh := trans1.Consumer()
+	go func() {
+		for {
+			select {
+			case rpc := <-rpcCh:
+				rpc.Respond(&resp, nil)
+			case <-time.After(200 * time.Millisecond):
+				return
+			}
+		}
+	}()
+
+	var out SyncResponse
+	if err := trans2.Sync(addr1, &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	trans2.Partition(addr1)
+	if err := trans2.Sync(addr1, &args, &out); err == nil {
+		t.Fatalf("expected error syncing with partitioned peer")
+	}
+
+	trans2.Heal(addr1)
+	if err := trans2.Sync(addr1, &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// TestInmemTransport_DropRPC verifies that a dropped RPC type fails instead
+// of being delivered.
+func TestInmemTransport_DropRPC(t *testing.T) {
+	addr1, trans1 := NewInmemTransport("")
+	defer trans1.Close()
+
+	addr2, trans2 := NewInmemTransport("")
+	defer trans2.Close()
+
+	connectTransports(addr1, trans1, addr2, trans2)
+
+	rpcCh := trans1.Consumer()
+	go func() {
+		for {
+			select {
+			case rpc := <-rpcCh:
+				rpc.Respond(&SyncResponse{}, nil)
+			case <-time.After(200 * time.Millisecond):
+				return
+			}
+		}
+	}()
+
+	trans2.DropRPC(&SyncRequest{})
+
+	var out SyncResponse
+	if err := trans2.Sync(addr1, &SyncRequest{From: "A"}, &out); err == nil {
+		t.Fatalf("expected dropped RPC to error")
+	}
+}
+
+func ExampleNewInmemTransport() {
+	addr, trans := NewInmemTransport("")
+	fmt.Println(addr == trans.LocalAddr())
+	trans.Close()
+	// Output: true
+}
@@ -0,0 +1,170 @@
+package net
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/babbleio/babble/common"
+)
+
+// genTestCert generates a self-signed certificate/key pair valid for
+// 127.0.0.1, with the given CommonName, for use as a throwaway client or
+// server identity in tests.
+func genTestCert(t *testing.T, commonName string) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+		Leaf:        cert,
+	}, cert
+}
+
+// tlsConfigs builds a pair of client-auth-enforcing tls.Config values (one
+// per side) that trust each other's self-signed certificate, mirroring how
+// two validators would be configured to dial one another.
+func tlsConfigs(t *testing.T, serverCert, clientCert tls.Certificate, trustServer, trustClient *x509.Certificate) (server, client *tls.Config) {
+	t.Helper()
+
+	serverPool := x509.NewCertPool()
+	serverPool.AddCert(trustClient)
+
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(trustServer)
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    serverPool,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      clientPool,
+		ServerName:   "127.0.0.1",
+	}
+	return server, client
+}
+
+func TestNetworkTransport_TLS_AuthorizedPeer(t *testing.T) {
+	serverCert, serverLeaf := genTestCert(t, "validator-1")
+	clientCert, clientLeaf := genTestCert(t, "validator-2")
+	serverTLS, clientTLS := tlsConfigs(t, serverCert, clientCert, serverLeaf, clientLeaf)
+
+	trans1, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr:  "127.0.0.1:0",
+		MaxPool:   2,
+		Timeout:   time.Second,
+		Logger:    common.NewTestLogger(t),
+		TLSConfig: serverTLS,
+		Authorize: func(cert *x509.Certificate) bool {
+			return cert.Subject.CommonName == "validator-2"
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	trans2, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr:  "127.0.0.1:0",
+		MaxPool:   2,
+		Timeout:   time.Second,
+		Logger:    common.NewTestLogger(t),
+		TLSConfig: clientTLS,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	args := SyncRequest{From: "A"}
+	resp := SyncResponse{From: "B"}
+
+	go func() {
+		select {
+		case rpc := <-rpcCh:
+			rpc.Respond(&resp, nil)
+		case <-time.After(200 * time.Millisecond):
+			t.Fatalf("timeout")
+		}
+	}()
+
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestNetworkTransport_TLS_RejectsUnauthorizedPeer(t *testing.T) {
+	serverCert, serverLeaf := genTestCert(t, "validator-1")
+	clientCert, clientLeaf := genTestCert(t, "intruder")
+	serverTLS, clientTLS := tlsConfigs(t, serverCert, clientCert, serverLeaf, clientLeaf)
+
+	trans1, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr:  "127.0.0.1:0",
+		MaxPool:   2,
+		Timeout:   time.Second,
+		Logger:    common.NewTestLogger(t),
+		TLSConfig: serverTLS,
+		Authorize: func(cert *x509.Certificate) bool {
+			// Only validator-2 is in the authorized validator set.
+			return cert.Subject.CommonName == "validator-2"
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+
+	trans2, err := NewTCPTransportWithConfig(&TCPTransportConfig{
+		BindAddr:  "127.0.0.1:0",
+		MaxPool:   2,
+		Timeout:   time.Second,
+		Logger:    common.NewTestLogger(t),
+		TLSConfig: clientTLS,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var out SyncResponse
+	err = trans2.Sync(trans1.LocalAddr(), &SyncRequest{From: "A"}, &out)
+	if err == nil {
+		t.Fatalf("expected unauthorized peer to be rejected")
+	}
+}
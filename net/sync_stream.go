@@ -0,0 +1,399 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/babbleio/babble/hashgraph"
+)
+
+// streamBufferSize bounds both the server-side sink and the client-side
+// event channel, so a fast producer or a slow consumer applies
+// back-pressure instead of buffering an unbounded number of events in
+// memory.
+const streamBufferSize = 64
+
+const (
+	streamFrameEvent uint8 = iota
+	streamFrameDone
+)
+
+// SyncStreamRequest is the command sent to open a streaming Sync.
+type SyncStreamRequest struct {
+	From  string
+	Known map[int]int
+}
+
+// syncStreamTrailer is sent once, after the last event, to close out a
+// streaming Sync with the responder's final Known map and any terminal
+// error.
+type syncStreamTrailer struct {
+	Known map[int]int
+	Error string
+}
+
+// StreamSink lets an RPC consumer push a stream of events back to the
+// caller before finally closing the stream with the replicated Known map.
+// It is delivered via RPC.Stream instead of RPC.RespChan when Command is a
+// *SyncStreamRequest.
+type StreamSink interface {
+	// Send delivers a single event to the caller. It blocks while the
+	// caller's receive buffer is full, and returns an error once the
+	// stream has been closed or cancelled.
+	Send(event *hashgraph.WireEvent) error
+
+	// Close ends the stream, delivering the final Known map (and error,
+	// if any) to the caller. It must be called exactly once, even if
+	// Send previously failed.
+	Close(known map[int]int, err error)
+}
+
+// streamItem is either a WireEvent payload or, for the final item, a
+// trailer carrying the replicated Known map and any terminal error.
+type streamItem struct {
+	event   *hashgraph.WireEvent
+	trailer *syncStreamTrailer
+}
+
+// serverStreamSink is the server-side StreamSink implementation: it
+// buffers items from the consumer so the connection's writer goroutine can
+// drain and frame them independently.
+type serverStreamSink struct {
+	ch     chan streamItem
+	closed chan struct{}
+	once   sync.Once
+
+	// cancelled is closed by handleSyncStream's writer goroutine when it
+	// stops servicing this stream, whether because the client went away,
+	// the connection errored, or the transport is shutting down. It lets
+	// a consumer blocked in Send or Close (buffer full, nobody draining
+	// it any more) return instead of leaking forever.
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+}
+
+func newServerStreamSink() *serverStreamSink {
+	return &serverStreamSink{
+		ch:        make(chan streamItem, streamBufferSize),
+		closed:    make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+// Send implements the StreamSink interface.
+func (s *serverStreamSink) Send(event *hashgraph.WireEvent) error {
+	select {
+	case s.ch <- streamItem{event: event}:
+		return nil
+	case <-s.closed:
+		return errors.New("babble-net: stream closed")
+	case <-s.cancelled:
+		return errors.New("babble-net: stream cancelled")
+	}
+}
+
+// Close implements the StreamSink interface.
+func (s *serverStreamSink) Close(known map[int]int, err error) {
+	s.once.Do(func() {
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		select {
+		case s.ch <- streamItem{trailer: &syncStreamTrailer{Known: known, Error: msg}}:
+		case <-s.cancelled:
+		}
+		close(s.closed)
+	})
+}
+
+// cancel releases any Send or Close call currently blocked on this sink. It
+// is safe to call more than once and from any goroutine.
+func (s *serverStreamSink) cancel() {
+	s.cancelOnce.Do(func() {
+		close(s.cancelled)
+	})
+}
+
+// SyncStream is the client-side handle for an in-flight streaming Sync.
+// Events are delivered in order on the Events channel; Done is closed once
+// the stream ends, after which Err and Known report the outcome.
+type SyncStream interface {
+	// Events yields WireEvents in the order the responder sent them.
+	Events() <-chan hashgraph.WireEvent
+
+	// Done is closed once the stream has ended, successfully or not.
+	Done() <-chan struct{}
+
+	// Err returns the terminal error, if any. Only meaningful once Done
+	// is closed.
+	Err() error
+
+	// Known returns the final Known map from the trailer. Only
+	// meaningful once Done is closed with a nil Err.
+	Known() map[int]int
+
+	// Close cancels the stream, releasing the underlying connection
+	// instead of returning it to the pool. It is a no-op if the stream
+	// has already ended.
+	Close() error
+}
+
+// StreamingTransport is implemented by transports that support streaming,
+// pipelined Sync in addition to the request/response RPCs of Transport.
+type StreamingTransport interface {
+	Transport
+
+	// SyncStream opens a streaming Sync against target. ctx cancels the
+	// stream; cancellation releases (rather than pools) the underlying
+	// connection.
+	SyncStream(ctx context.Context, target string, args *SyncStreamRequest) (SyncStream, error)
+}
+
+// clientSyncStream implements SyncStream over a pinned pooled connection.
+type clientSyncStream struct {
+	t    *TCPTransport
+	conn *netConn
+
+	events chan hashgraph.WireEvent
+	done   chan struct{}
+
+	err   error
+	known map[int]int
+
+	// disposeOnce guards the pinned conn's single disposition: exactly one
+	// of releaseConn, poolConn, or abortConn ever actually touches it, no
+	// matter which of run (reaching a terminal frame) and Close/ctx
+	// cancellation (racing to abort) gets there first. This is what stops
+	// an aborting caller from closing a connection run has already handed
+	// back to the pool.
+	disposeOnce sync.Once
+}
+
+// releaseConn discards the conn instead of pooling it, e.g. because a read
+// or decode failed.
+func (s *clientSyncStream) releaseConn() {
+	s.disposeOnce.Do(func() {
+		s.conn.Release()
+	})
+}
+
+// poolConn returns the conn to the pool after a clean trailer read.
+func (s *clientSyncStream) poolConn() {
+	s.disposeOnce.Do(func() {
+		s.t.returnConn(s.conn)
+	})
+}
+
+// abortConn forcibly closes the conn to unblock a read run is blocked in.
+// If run has already called releaseConn or poolConn, this is a no-op, so
+// abortConn can never close a connection that's already back in the pool.
+func (s *clientSyncStream) abortConn() {
+	s.disposeOnce.Do(func() {
+		s.conn.conn.Close()
+	})
+}
+
+// Events implements the SyncStream interface.
+func (s *clientSyncStream) Events() <-chan hashgraph.WireEvent {
+	return s.events
+}
+
+// Done implements the SyncStream interface.
+func (s *clientSyncStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err implements the SyncStream interface.
+func (s *clientSyncStream) Err() error {
+	return s.err
+}
+
+// Known implements the SyncStream interface.
+func (s *clientSyncStream) Known() map[int]int {
+	return s.known
+}
+
+// Close implements the SyncStream interface.
+func (s *clientSyncStream) Close() error {
+	s.abortConn()
+	return nil
+}
+
+// readErr reports ctx's error in place of err whenever ctx has already
+// ended, since a read failing while the watcher goroutine is forcibly
+// closing the conn surfaces as a generic "use of closed network
+// connection" rather than the cancellation that actually caused it.
+func readErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// run reads frames off the pinned connection until the trailer arrives, an
+// error occurs, or ctx is cancelled, delivering events on s.events. A
+// watcher goroutine forces the conn closed on ctx cancellation, so a read
+// blocked mid-stream (the peer accepted the stream and then stalled) is
+// interrupted instead of hanging until the OS TCP timeout.
+func (s *clientSyncStream) run(ctx context.Context) {
+	defer close(s.done)
+	defer close(s.events)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.abortConn()
+		case <-s.done:
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			s.releaseConn()
+			return
+		default:
+		}
+
+		status, err := s.conn.r.ReadByte()
+		if err != nil {
+			s.err = readErr(ctx, err)
+			s.releaseConn()
+			return
+		}
+
+		if status == streamFrameDone {
+			var trailer syncStreamTrailer
+			n, err := readFrame(s.conn.r, s.conn.codec, &trailer)
+			if err != nil {
+				s.err = readErr(ctx, err)
+				s.releaseConn()
+				return
+			}
+			s.t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "in", "SyncStream"}, float32(n))
+			s.known = trailer.Known
+			if trailer.Error != "" {
+				s.err = errors.New(trailer.Error)
+			}
+			s.poolConn()
+			return
+		}
+
+		var event hashgraph.WireEvent
+		n, err := readFrame(s.conn.r, s.conn.codec, &event)
+		if err != nil {
+			s.err = readErr(ctx, err)
+			s.releaseConn()
+			return
+		}
+		s.t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "in", "SyncStream"}, float32(n))
+
+		select {
+		case s.events <- event:
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			s.releaseConn()
+			return
+		}
+	}
+}
+
+// SyncStream implements the StreamingTransport interface.
+func (t *TCPTransport) SyncStream(ctx context.Context, target string, args *SyncStreamRequest) (SyncStream, error) {
+	t.metrics.IncrCounter([]string{"babble", "net", "rpc", "outbound", "SyncStream"}, 1)
+
+	conn, err := t.getConn(target)
+	if err != nil {
+		return nil, err
+	}
+	// conn may be pooled from a prior Sync/EagerSync/FastForward call that
+	// left a now-stale genericRPC deadline set; clear it before pinning
+	// the conn for the life of the stream.
+	conn.conn.SetDeadline(time.Time{})
+
+	if err := conn.w.WriteByte(rpcSyncStream); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	sent, err := writeFrame(conn.w, conn.codec, args)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "out", "SyncStream"}, float32(sent))
+	if err := conn.w.Flush(); err != nil {
+		conn.Release()
+		return nil, err
+	}
+
+	stream := &clientSyncStream{
+		t:      t,
+		conn:   conn,
+		events: make(chan hashgraph.WireEvent, streamBufferSize),
+		done:   make(chan struct{}),
+	}
+	go stream.run(ctx)
+	return stream, nil
+}
+
+// handleSyncStream services an inbound rpcSyncStream command: it decodes
+// the request, hands a StreamSink to the consumer, and relays every event
+// (and the final trailer) the consumer produces back over the wire.
+func (t *TCPTransport) handleSyncStream(r *bufio.Reader, w *bufio.Writer, c Codec) error {
+	t.metrics.IncrCounter([]string{"babble", "net", "rpc", "inbound", "SyncStream"}, 1)
+
+	var req SyncStreamRequest
+	received, err := readFrame(r, c, &req)
+	if err != nil {
+		return err
+	}
+	t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "in", "SyncStream"}, float32(received))
+
+	sink := newServerStreamSink()
+	// Whatever ends this function's loop below - a write error because
+	// the peer went away, a shutdown, or a clean finish - release any
+	// consumer goroutine still blocked in sink.Send/Close so it can't
+	// leak waiting on a writer that has already stopped draining sink.ch.
+	defer sink.cancel()
+	rpc := RPC{Command: &req, Stream: sink}
+
+	select {
+	case t.consumeCh <- rpc:
+	case <-t.shutdownCh:
+		return fmt.Errorf("transport shutdown")
+	}
+
+	for {
+		select {
+		case item := <-sink.ch:
+			if item.trailer != nil {
+				if err := w.WriteByte(streamFrameDone); err != nil {
+					return err
+				}
+				sent, err := writeFrame(w, c, item.trailer)
+				t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "out", "SyncStream"}, float32(sent))
+				return err
+			}
+
+			if err := w.WriteByte(streamFrameEvent); err != nil {
+				return err
+			}
+			sent, err := writeFrame(w, c, item.event)
+			if err != nil {
+				return err
+			}
+			t.metrics.AddSample([]string{"babble", "net", "rpc", "bytes", "out", "SyncStream"}, float32(sent))
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case <-t.shutdownCh:
+			return fmt.Errorf("transport shutdown")
+		}
+	}
+}
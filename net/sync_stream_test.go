@@ -0,0 +1,213 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/babbleio/babble/common"
+	"github.com/babbleio/babble/hashgraph"
+)
+
+func TestNetworkTransport_SyncStream_OrderedDelivery(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	const n = 50
+	go func() {
+		select {
+		case rpc := <-rpcCh:
+			req, ok := rpc.Command.(*SyncStreamRequest)
+			if !ok {
+				t.Errorf("unexpected command type %T", rpc.Command)
+				return
+			}
+			if req.From != "A" {
+				t.Errorf("unexpected From: %v", req.From)
+			}
+			for i := 0; i < n; i++ {
+				event := hashgraph.WireEvent{Body: hashgraph.WireBody{SelfParentIndex: i}}
+				if err := rpc.Stream.Send(&event); err != nil {
+					t.Errorf("err: %v", err)
+					return
+				}
+			}
+			rpc.Stream.Close(map[int]int{0: n}, nil)
+		case <-time.After(time.Second):
+			t.Errorf("timeout")
+		}
+	}()
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := trans2.SyncStream(ctx, trans1.LocalAddr(), &SyncStreamRequest{From: "A"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var got []hashgraph.WireEvent
+	for event := range stream.Events() {
+		got = append(got, event)
+	}
+	<-stream.Done()
+
+	if err := stream.Err(); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	if len(got) != n {
+		t.Fatalf("expected %d events, got %d", n, len(got))
+	}
+	for i, event := range got {
+		if event.Body.SelfParentIndex != i {
+			t.Fatalf("out of order delivery at %d: %#v", i, event)
+		}
+	}
+	if stream.Known()[0] != n {
+		t.Fatalf("unexpected trailer Known: %#v", stream.Known())
+	}
+}
+
+func TestNetworkTransport_SyncStream_MidStreamError(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	go func() {
+		select {
+		case rpc := <-rpcCh:
+			event := hashgraph.WireEvent{Body: hashgraph.WireBody{SelfParentIndex: 0}}
+			if err := rpc.Stream.Send(&event); err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			rpc.Stream.Close(nil, errors.New("boom"))
+		case <-time.After(time.Second):
+			t.Errorf("timeout")
+		}
+	}()
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := trans2.SyncStream(ctx, trans1.LocalAddr(), &SyncStreamRequest{From: "A"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	count := 0
+	for range stream.Events() {
+		count++
+	}
+	<-stream.Done()
+
+	if count != 1 {
+		t.Fatalf("expected 1 event before the error, got %d", count)
+	}
+	if stream.Err() == nil || stream.Err().Error() != "boom" {
+		t.Fatalf("expected error %q, got %v", "boom", stream.Err())
+	}
+}
+
+func TestNetworkTransport_SyncStream_ContextCancelUnblocksStalledRead(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	// The responder accepts the stream but never calls Send or Close,
+	// simulating a peer whose consumer is stuck mid-Send.
+	go func() {
+		select {
+		case <-rpcCh:
+		case <-time.After(time.Second):
+			t.Errorf("timeout")
+		}
+	}()
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	stream, err := trans2.SyncStream(ctx, trans1.LocalAddr(), &SyncStreamRequest{From: "A"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case <-stream.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("stream did not unblock within 1s of ctx cancellation")
+	}
+
+	if stream.Err() != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, stream.Err())
+	}
+}
+
+func TestNetworkTransport_SyncStream_ReturnsConnToPool(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	go func() {
+		select {
+		case rpc := <-rpcCh:
+			rpc.Stream.Close(map[int]int{}, nil)
+		case <-time.After(time.Second):
+			t.Errorf("timeout")
+		}
+	}()
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	addr := trans1.LocalAddr()
+	stream, err := trans2.SyncStream(ctx, addr, &SyncStreamRequest{From: "A"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for range stream.Events() {
+	}
+	<-stream.Done()
+
+	if len(trans2.connPool[addr]) != 1 {
+		t.Fatalf("expected stream's connection to be returned to the pool, got %d pooled conns", len(trans2.connPool[addr]))
+	}
+}
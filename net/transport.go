@@ -0,0 +1,91 @@
+package net
+
+import (
+	"github.com/babbleio/babble/hashgraph"
+)
+
+// RPCResponse captures both a response and a potential error.
+type RPCResponse struct {
+	Response interface{}
+	Error    error
+}
+
+// RPC has a command, and provides a response mechanism.
+type RPC struct {
+	Command  interface{}
+	RespChan chan<- RPCResponse
+
+	// Stream is set instead of RespChan when Command is a
+	// *SyncStreamRequest: the consumer pushes events with Stream.Send and
+	// ends the exchange with Stream.Close, rather than a single Respond.
+	Stream StreamSink
+}
+
+// Respond is used to respond with a response, error or both
+func (r *RPC) Respond(resp interface{}, err error) {
+	r.RespChan <- RPCResponse{resp, err}
+}
+
+// SyncRequest is the command sent to request a Sync
+type SyncRequest struct {
+	From  string
+	Known map[int]int
+}
+
+// SyncResponse is the response returned from a Sync request
+type SyncResponse struct {
+	From   string
+	Events []hashgraph.WireEvent
+	Known  map[int]int
+}
+
+// EagerSyncRequest is the command sent to push Events onto a peer
+type EagerSyncRequest struct {
+	From   string
+	Events []hashgraph.WireEvent
+}
+
+// EagerSyncResponse is the response returned from an EagerSync request
+type EagerSyncResponse struct {
+	From    string
+	Success bool
+}
+
+// FastForwardRequest is the command sent to request a Frame from a peer
+type FastForwardRequest struct {
+	From string
+}
+
+// FastForwardResponse is the response returned from a FastForward request
+type FastForwardResponse struct {
+	From  string
+	Frame hashgraph.Frame
+}
+
+// Transport provides an interface for network transports to allow Babble's
+// gossip protocol to transmit RPCs. Callers are responsible for
+// understanding the concurrency properties of each implementation.
+type Transport interface {
+	// Consumer returns a channel that can be used to consume and respond to
+	// RPC requests.
+	Consumer() <-chan RPC
+
+	// LocalAddr is used to return our local address to distinguish from
+	// our peers.
+	LocalAddr() string
+
+	// Sync sends a SyncRequest to the target and waits for a response
+	Sync(target string, args *SyncRequest, resp *SyncResponse) error
+
+	// EagerSync sends an EagerSyncRequest to the target and waits for a
+	// response
+	EagerSync(target string, args *EagerSyncRequest, resp *EagerSyncResponse) error
+
+	// FastForward sends a FastForwardRequest to the target and waits for a
+	// response
+	FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error
+
+	// Close permanently shuts down the transport, closing all connections
+	// and listeners.
+	Close() error
+}